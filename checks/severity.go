@@ -0,0 +1,21 @@
+package checks
+
+// Severity represents how seriously a failing check should be treated.
+// Only SeverityError failures take an instance out of rotation (flipping
+// the gRPC serving status to NOT_SERVING and the `/ready` HTTP status to
+// 503); SeverityWarn and SeverityInfo failures are still reported in the
+// `?full=1` JSON output but don't affect serving status.
+type Severity string
+
+const (
+	// SeverityInfo marks a failure as informational only, e.g. a cache
+	// that hasn't warmed up yet.
+	SeverityInfo Severity = "info"
+	// SeverityWarn marks a failure as degraded but tolerable, e.g. one of
+	// several redundant upstreams being down.
+	SeverityWarn Severity = "warn"
+	// SeverityError marks a failure as serious enough to take the
+	// instance out of rotation. This is the default severity used by
+	// AddReadinessCheck and AddLivenessCheck.
+	SeverityError Severity = "error"
+)
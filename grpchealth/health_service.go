@@ -0,0 +1,73 @@
+// Package grpchealth implements a grpc_health_v1.HealthServer that answers
+// Check and Watch RPCs directly from a handlers.GrpcHandler's own check
+// state, so a user can register it on their own *grpc.Server without
+// separately wiring up and maintaining gRPC's stock health.Server.
+package grpchealth
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/GlobalWebIndex/healthcheck/handlers"
+)
+
+// HealthService implements grpc_health_v1.HealthServer, reflecting the
+// serving status that h already computes from its own registered checks,
+// gRPC dependencies and services.
+type HealthService struct {
+	h handlers.GrpcHandler
+}
+
+// NewHealthService wraps h so it can be registered on a *grpc.Server via
+// grpc_health_v1.RegisterHealthServer(s, grpchealth.NewHealthService(h)).
+func NewHealthService(h handlers.GrpcHandler) *HealthService {
+	return &HealthService{h: h}
+}
+
+// Check implements grpc_health_v1.HealthServer. It returns codes.NotFound
+// for a service nobody registered, matching the gRPC Health Checking
+// Protocol and the behavior of the stock health.Server this package
+// replaces.
+func (hs *HealthService) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	if !hs.h.KnownService(req.Service) {
+		return nil, status.Error(codes.NotFound, "unknown service")
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: hs.h.ServingStatus(req.Service)}, nil
+}
+
+// Watch implements grpc_health_v1.HealthServer, streaming req.Service's
+// serving status every time it changes, starting with its current value.
+// It returns codes.NotFound for a service nobody registered, same as
+// Check.
+func (hs *HealthService) Watch(req *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	if !hs.h.KnownService(req.Service) {
+		return status.Error(codes.NotFound, "unknown service")
+	}
+
+	updates, cancel := hs.h.SubscribeServingStatus(req.Service)
+	defer cancel()
+
+	last := hs.h.ServingStatus(req.Service)
+	if err := stream.Send(&grpc_health_v1.HealthCheckResponse{Status: last}); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case status := <-updates:
+			if status == last {
+				continue
+			}
+			last = status
+			if err := stream.Send(&grpc_health_v1.HealthCheckResponse{Status: last}); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package grpchealth_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/GlobalWebIndex/healthcheck/grpchealth"
+	"github.com/GlobalWebIndex/healthcheck/handlers"
+)
+
+func TestCheckReflectsRegisteredChecks(t *testing.T) {
+	hs := health.NewServer()
+	h := handlers.NewGrpcHandler(hs)
+	defer h.Close()
+
+	failing := make(chan struct{})
+	require.NoError(t, h.AddReadinessCheck("dep", func() error {
+		select {
+		case <-failing:
+			return errors.New("dep is down")
+		default:
+			return nil
+		}
+	}, time.Millisecond))
+
+	svc := grpchealth.NewHealthService(h)
+
+	waitForCheckStatus(t, svc, "", grpc_health_v1.HealthCheckResponse_SERVING)
+
+	close(failing)
+
+	waitForCheckStatus(t, svc, "", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+}
+
+func waitForCheckStatus(t *testing.T, svc *grpchealth.HealthService, service string, want grpc_health_v1.HealthCheckResponse_ServingStatus) {
+	t.Helper()
+
+	deadline := time.After(2 * time.Second)
+	for {
+		resp, err := svc.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		if resp.Status == want {
+			return
+		}
+
+		select {
+		case <-deadline:
+			t.Fatalf("service %q never reached serving status %s", service, want)
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func TestCheckReturnsNotFoundForUnregisteredService(t *testing.T) {
+	hs := health.NewServer()
+	h := handlers.NewGrpcHandler(hs)
+	defer h.Close()
+
+	require.NoError(t, h.AddReadinessCheck("dep", func() error { return nil }, time.Millisecond))
+
+	svc := grpchealth.NewHealthService(h)
+
+	_, err := svc.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: "no-such-service"})
+	require.Error(t, err)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestWatchReturnsNotFoundForUnregisteredService(t *testing.T) {
+	hs := health.NewServer()
+	h := handlers.NewGrpcHandler(hs)
+	defer h.Close()
+
+	svc := grpchealth.NewHealthService(h)
+	stream := &fakeWatchServer{ctx: context.Background(), recv: make(chan *grpc_health_v1.HealthCheckResponse, 1)}
+
+	err := svc.Watch(&grpc_health_v1.HealthCheckRequest{Service: "no-such-service"}, stream)
+	require.Error(t, err)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+// fakeWatchServer implements grpc_health_v1.Health_WatchServer, recording
+// every response sent to it on a channel for the test to inspect.
+type fakeWatchServer struct {
+	grpc.ServerStream
+
+	ctx  context.Context
+	recv chan *grpc_health_v1.HealthCheckResponse
+}
+
+func (f *fakeWatchServer) Send(resp *grpc_health_v1.HealthCheckResponse) error {
+	f.recv <- resp
+	return nil
+}
+
+func (f *fakeWatchServer) Context() context.Context {
+	return f.ctx
+}
+
+func TestWatchStreamsStatusChanges(t *testing.T) {
+	hs := health.NewServer()
+	h := handlers.NewGrpcHandler(hs)
+	defer h.Close()
+
+	failing := make(chan struct{})
+	require.NoError(t, h.AddReadinessCheck("dep", func() error {
+		select {
+		case <-failing:
+			return errors.New("dep is down")
+		default:
+			return nil
+		}
+	}, time.Millisecond))
+
+	svc := grpchealth.NewHealthService(h)
+	stream := &fakeWatchServer{ctx: context.Background(), recv: make(chan *grpc_health_v1.HealthCheckResponse, 10)}
+
+	go func() {
+		_ = svc.Watch(&grpc_health_v1.HealthCheckRequest{Service: ""}, stream)
+	}()
+
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, (<-stream.recv).Status)
+
+	close(failing)
+
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case resp := <-stream.recv:
+			if resp.Status == grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+				return
+			}
+		case <-deadline:
+			t.Fatal("Watch never streamed the NOT_SERVING transition")
+		}
+	}
+}
@@ -5,19 +5,49 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
+	"math/rand"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
 
 	"github.com/GlobalWebIndex/healthcheck/checks"
+	"github.com/GlobalWebIndex/healthcheck/metrics"
 )
 
+// Default backoff settings used to reconnect a broken gRPC Watch stream,
+// see WithGrpcWatchBackoff.
+const (
+	defaultGrpcWatchBackoffMin    = 1 * time.Second
+	defaultGrpcWatchBackoffMax    = 120 * time.Second
+	defaultGrpcWatchBackoffJitter = 0.2
+)
+
+// defaultService is the gRPC Health service name used by the non
+// "...ForService" check registration methods, as well as for the overall
+// serving status that is the AND of every known service.
+const defaultService = ""
+
+// checkState is the last recorded outcome of a readiness or liveness
+// check, along with the severity it was registered with and a short
+// history of its recent runs, used to populate the `?format=v2` JSON
+// envelope.
+type checkState struct {
+	err      error
+	severity checks.Severity
+
+	lastCheck          time.Time
+	lastSuccess        time.Time
+	lastDuration       time.Duration
+	contiguousFailures int
+}
+
 type grpcHandler struct {
 	http.ServeMux
 
@@ -25,12 +55,21 @@ type grpcHandler struct {
 	globalCancel  context.CancelFunc
 
 	checksMutex     sync.RWMutex
-	readinessChecks map[string]error
-	livenessChecks  map[string]error
+	readinessChecks map[string]map[string]*checkState // service -> check name -> last state
+	livenessChecks  map[string]map[string]*checkState // service -> check name -> last state
 
 	grpcDepsMutex    sync.RWMutex
 	grpcHealthServer *health.Server
-	grpcDeps         map[string]bool
+	grpcDeps         map[string]map[string]bool // service -> dep name -> serving
+
+	watchersMutex sync.Mutex
+	watchers      map[string]map[chan grpc_health_v1.HealthCheckResponse_ServingStatus]struct{} // service -> subscriber channels
+
+	grpcWatchBackoffMin    time.Duration
+	grpcWatchBackoffMax    time.Duration
+	grpcWatchBackoffJitter float64
+
+	metrics *metrics.Metrics
 
 	log *zap.Logger
 }
@@ -45,24 +84,53 @@ func WithZapLogger(log *zap.Logger) GrpcHandlerOption {
 	}
 }
 
+// WithGrpcWatchBackoff configures the exponential backoff used to reconnect
+// a gRPC Watch stream after it breaks: the first reconnect attempt waits
+// around min, each subsequent failure doubles the wait up to max, and
+// jitter (0..1) randomizes every wait by +/- jitter*wait so that many
+// dependents reconnecting at once don't all hammer the dependency in
+// lockstep.
+func WithGrpcWatchBackoff(min, max time.Duration, jitter float64) GrpcHandlerOption {
+	return func(g *grpcHandler) {
+		g.grpcWatchBackoffMin = min
+		g.grpcWatchBackoffMax = max
+		g.grpcWatchBackoffJitter = jitter
+	}
+}
+
+// WithPrometheusRegisterer exports a healthcheck_status gauge, a
+// healthcheck_duration_seconds histogram and a healthcheck_failures_total
+// counter to reg, labeled by check name and kind ("liveness", "readiness"
+// or "grpc_dep"), for every check registered on this handler.
+func WithPrometheusRegisterer(reg prometheus.Registerer) GrpcHandlerOption {
+	return func(g *grpcHandler) {
+		g.metrics = metrics.New(reg)
+	}
+}
+
 func NewGrpcHandler(hs *health.Server, opts ...GrpcHandlerOption) GrpcHandler {
 	// set up global context with cancel
 	gctx, cancel := context.WithCancel(context.Background())
 
 	h := &grpcHandler{
-		livenessChecks:   make(map[string]error),
-		readinessChecks:  make(map[string]error),
+		livenessChecks:   make(map[string]map[string]*checkState),
+		readinessChecks:  make(map[string]map[string]*checkState),
 		grpcHealthServer: hs,
-		grpcDeps:         make(map[string]bool),
+		grpcDeps:         make(map[string]map[string]bool),
+		watchers:         make(map[string]map[chan grpc_health_v1.HealthCheckResponse_ServingStatus]struct{}),
 		globalContext:    gctx,
 		globalCancel:     cancel,
+
+		grpcWatchBackoffMin:    defaultGrpcWatchBackoffMin,
+		grpcWatchBackoffMax:    defaultGrpcWatchBackoffMax,
+		grpcWatchBackoffJitter: defaultGrpcWatchBackoffJitter,
 	}
 
 	for _, opt := range opts {
 		opt(h)
 	}
 
-	h.grpcHealthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	h.grpcHealthServer.SetServingStatus(defaultService, grpc_health_v1.HealthCheckResponse_NOT_SERVING)
 
 	h.Handle("/live", http.HandlerFunc(h.LiveEndpoint))
 	h.Handle("/ready", http.HandlerFunc(h.ReadyEndpoint))
@@ -74,106 +142,352 @@ func (s *grpcHandler) Close() {
 	s.globalCancel()
 }
 
+// AddGrpcReadinessCheck opens a stream (Watch rpc of Health service) and
+// listens for health status changes of a gRPC dependency, scoped to the
+// overall ("") service. See AddGrpcReadinessCheckForService.
 func (s *grpcHandler) AddGrpcReadinessCheck(name string, grpcClient grpc_health_v1.HealthClient) error {
+	return s.AddGrpcReadinessCheckForService(defaultService, name, grpcClient)
+}
 
+// AddGrpcReadinessCheckForService is like AddGrpcReadinessCheck, but scopes
+// the dependency to a named gRPC service: flipping it only affects that
+// service's serving status (and, transitively, the overall "" status).
+func (s *grpcHandler) AddGrpcReadinessCheckForService(service, name string, grpcClient grpc_health_v1.HealthClient) error {
 	s.grpcDepsMutex.Lock()
-	defer s.grpcDepsMutex.Unlock()
 
-	if _, ok := s.grpcDeps[name]; ok {
-		return fmt.Errorf("grpc readiness check '%s' already exists", name)
+	if s.grpcDeps[service] == nil {
+		s.grpcDeps[service] = make(map[string]bool)
+	}
+	if _, ok := s.grpcDeps[service][name]; ok {
+		s.grpcDepsMutex.Unlock()
+		return fmt.Errorf("grpc readiness check '%s' already exists for service '%s'", name, service)
 	}
 
 	// we start in failed state
-	s.grpcDeps[name] = false
+	s.grpcDeps[service][name] = false
+	s.grpcDepsMutex.Unlock()
 
 	stream, err := grpcClient.Watch(s.globalContext, &grpc_health_v1.HealthCheckRequest{})
 	if err != nil {
 		return err
 	}
 
-	go func() {
-		for {
-			resp, err := stream.Recv()
-			switch {
-			case err == io.EOF:
-				break
+	go s.watchGrpcDep(service, name, grpcClient, stream)
 
-			case err != nil:
-				if s.log != nil {
-					s.log.Warn("Readiness check for gRPC service failed on `stream.Recv()`",
-						zap.String("name", name), zap.Error(err))
-				}
+	return nil
+}
+
+// watchGrpcDep consumes a gRPC Health Watch stream for dependency `name` of
+// `service`, reflecting every status change it observes. If the stream
+// breaks for any reason other than the global context being canceled (e.g.
+// a transient network blip), the dependency is marked NOT_SERVING and a new
+// stream is opened after an exponential backoff delay instead of leaving
+// the check stuck in its last observed state forever.
+func (s *grpcHandler) watchGrpcDep(service, name string, grpcClient grpc_health_v1.HealthClient, stream grpc_health_v1.Health_WatchClient) {
+	backoff := s.grpcWatchBackoffMin
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if s.globalContext.Err() != nil {
 				return
 			}
 
+			if s.log != nil {
+				s.log.Warn("Readiness check for gRPC service failed on `stream.Recv()`, reconnecting",
+					zap.String("service", service), zap.String("name", name), zap.Error(err), zap.Duration("backoff", backoff))
+			}
+
 			s.grpcDepsMutex.Lock()
+			s.grpcDeps[service][name] = false
+			s.grpcDepsMutex.Unlock()
 
-			switch {
-			// grpc dep was KO, now it is fine
-			case resp.Status == grpc_health_v1.HealthCheckResponse_SERVING && !s.grpcDeps[name]:
-				if s.log != nil {
-					s.log.Debug("Grpc readiness check (watch): NOT_SERVING --> SERVING", zap.String("name", name))
-				}
-				s.grpcDeps[name] = true
+			if s.metrics != nil {
+				s.metrics.Observe(name, "grpc_dep", err, 0)
+			}
 
-				s.grpcDepsMutex.Unlock()
+			s.updateServingStatus(service)
 
-				// we must check a) other grpc deps b) readiness/liveness checks before setting
-				// serving status back to normal
+			if !s.sleepBackoff(jitterDuration(backoff, s.grpcWatchBackoffJitter)) {
+				return
+			}
 
-				ok := true
-				ok = ok && s.areGrpcDepsOk()
-				ok = ok && s.areChecksOk()
+			backoff *= 2
+			if backoff > s.grpcWatchBackoffMax {
+				backoff = s.grpcWatchBackoffMax
+			}
 
-				if !ok {
-					continue
-				}
+			newStream, err := grpcClient.Watch(s.globalContext, &grpc_health_v1.HealthCheckRequest{})
+			if err != nil {
+				continue
+			}
+			stream = newStream
+			continue
+		}
 
-				s.grpcHealthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+		// connection is healthy again, reset the backoff for the next failure
+		backoff = s.grpcWatchBackoffMin
 
-			// grpc dep was fine, now it is KO
-			case resp.Status == grpc_health_v1.HealthCheckResponse_NOT_SERVING && s.grpcDeps[name]:
-				if s.log != nil {
-					s.log.Debug("Grpc readiness check (watch): SERVING --> NOT_SERVING", zap.String("name", name))
-				}
-				s.grpcDeps[name] = false
+		s.grpcDepsMutex.Lock()
 
-				s.grpcDepsMutex.Unlock()
+		switch {
+		// grpc dep was KO, now it is fine
+		case resp.Status == grpc_health_v1.HealthCheckResponse_SERVING && !s.grpcDeps[service][name]:
+			if s.log != nil {
+				s.log.Debug("Grpc readiness check (watch): NOT_SERVING --> SERVING", zap.String("service", service), zap.String("name", name))
+			}
+			s.grpcDeps[service][name] = true
 
-				s.grpcHealthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+			s.grpcDepsMutex.Unlock()
 
-			default:
-				if s.log != nil {
-					s.log.Debug("Grpc readiness check (watch): unexpected status received", zap.String("name", name), zap.String("received_status", resp.Status.String()))
-				}
-				s.grpcDepsMutex.Unlock()
+			if s.metrics != nil {
+				s.metrics.Observe(name, "grpc_dep", nil, 0)
 			}
+
+			s.updateServingStatus(service)
+
+		// grpc dep was fine, now it is KO
+		case resp.Status == grpc_health_v1.HealthCheckResponse_NOT_SERVING && s.grpcDeps[service][name]:
+			if s.log != nil {
+				s.log.Debug("Grpc readiness check (watch): SERVING --> NOT_SERVING", zap.String("service", service), zap.String("name", name))
+			}
+			s.grpcDeps[service][name] = false
+
+			s.grpcDepsMutex.Unlock()
+
+			if s.metrics != nil {
+				s.metrics.Observe(name, "grpc_dep", fmt.Errorf("grpc service is down"), 0)
+			}
+
+			s.updateServingStatus(service)
+
+		default:
+			if s.log != nil {
+				s.log.Debug("Grpc readiness check (watch): unexpected status received", zap.String("service", service), zap.String("name", name), zap.String("received_status", resp.Status.String()))
+			}
+			s.grpcDepsMutex.Unlock()
 		}
-	}()
+	}
+}
 
-	return nil
+// sleepBackoff waits for d, or returns false early if the global context is
+// canceled first.
+func (s *grpcHandler) sleepBackoff(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-s.globalContext.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
 }
 
-// areChecksOk returns true only when all readiness and liveness check
-// function's last executions had no errors. Otherwise, false is returned.
-func (s *grpcHandler) areChecksOk() (ok bool) {
-	ok = true
+// jitterDuration randomizes d by up to +/- jitter*d (jitter is clamped to
+// [0, 1]).
+func jitterDuration(d time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return d
+	}
+	if jitter > 1 {
+		jitter = 1
+	}
+	delta := float64(d) * jitter
+	return d + time.Duration((rand.Float64()*2-1)*delta)
+}
+
+// serviceOk reports whether every SeverityError readiness check, liveness
+// check and grpc dependency registered for service currently passes.
+// SeverityWarn and SeverityInfo failures don't affect the result.
+func (s *grpcHandler) serviceOk(service string) bool {
 	s.checksMutex.RLock()
-	defer s.checksMutex.RUnlock()
+	for _, state := range s.readinessChecks[service] {
+		if state.err != nil && state.severity == checks.SeverityError {
+			s.checksMutex.RUnlock()
+			return false
+		}
+	}
+	for _, state := range s.livenessChecks[service] {
+		if state.err != nil && state.severity == checks.SeverityError {
+			s.checksMutex.RUnlock()
+			return false
+		}
+	}
+	s.checksMutex.RUnlock()
 
-	for _, checkResult := range s.readinessChecks {
-		if checkResult != nil {
-			ok = false
-			return
+	s.grpcDepsMutex.RLock()
+	defer s.grpcDepsMutex.RUnlock()
+	for _, up := range s.grpcDeps[service] {
+		if !up {
+			return false
 		}
 	}
-	for _, checkResult := range s.livenessChecks {
-		if checkResult != nil {
-			ok = false
-			return
+	return true
+}
+
+// knownServices returns every service name that has at least one check
+// registered under it, across readiness checks, liveness checks and grpc
+// dependencies.
+func (s *grpcHandler) knownServices() []string {
+	seen := make(map[string]struct{})
+
+	s.checksMutex.RLock()
+	for service := range s.readinessChecks {
+		seen[service] = struct{}{}
+	}
+	for service := range s.livenessChecks {
+		seen[service] = struct{}{}
+	}
+	s.checksMutex.RUnlock()
+
+	s.grpcDepsMutex.RLock()
+	for service := range s.grpcDeps {
+		seen[service] = struct{}{}
+	}
+	s.grpcDepsMutex.RUnlock()
+
+	services := make([]string, 0, len(seen))
+	for service := range seen {
+		services = append(services, service)
+	}
+	return services
+}
+
+// KnownService reports whether service has at least one readiness check,
+// liveness check or grpc dependency registered, or is the overall ""
+// service (which is always known). It's used by the grpchealth subpackage
+// to return codes.NotFound for a service nobody registered, matching the
+// gRPC Health Checking Protocol.
+func (s *grpcHandler) KnownService(service string) bool {
+	if service == defaultService {
+		return true
+	}
+	for _, known := range s.knownServices() {
+		if known == service {
+			return true
 		}
 	}
-	return
+	return false
+}
+
+// computeServingStatus reports what the serving status of service should be
+// right now: for the overall "" service, the AND of every known service;
+// for any other service, whether its own checks currently pass.
+func (s *grpcHandler) computeServingStatus(service string) grpc_health_v1.HealthCheckResponse_ServingStatus {
+	if service == defaultService {
+		for _, known := range s.knownServices() {
+			if !s.serviceOk(known) {
+				return grpc_health_v1.HealthCheckResponse_NOT_SERVING
+			}
+		}
+		return grpc_health_v1.HealthCheckResponse_SERVING
+	}
+
+	if s.serviceOk(service) {
+		return grpc_health_v1.HealthCheckResponse_SERVING
+	}
+	return grpc_health_v1.HealthCheckResponse_NOT_SERVING
+}
+
+// updateServingStatus recomputes and publishes the serving status of
+// service (unless it is the overall "" service, which has no status of its
+// own to recompute independently), then recomputes the overall "" status as
+// the AND of every known service. Every recomputation is also pushed to any
+// Watch subscribers registered via SubscribeServingStatus.
+func (s *grpcHandler) updateServingStatus(service string) {
+	if service != defaultService {
+		status := s.computeServingStatus(service)
+		s.grpcHealthServer.SetServingStatus(service, status)
+		s.notifyWatchers(service, status)
+	}
+
+	overall := s.computeServingStatus(defaultService)
+	s.grpcHealthServer.SetServingStatus(defaultService, overall)
+	s.notifyWatchers(defaultService, overall)
+}
+
+// ServingStatus reports service's current gRPC serving status, computed
+// directly from its registered checks, gRPC dependencies and other
+// services (for the overall "" service) rather than mirrored from the
+// externally-owned *health.Server passed to NewGrpcHandler.
+func (s *grpcHandler) ServingStatus(service string) grpc_health_v1.HealthCheckResponse_ServingStatus {
+	return s.computeServingStatus(service)
+}
+
+// SubscribeServingStatus registers a subscriber for service's serving
+// status: a value is sent on the returned channel every time the status is
+// recomputed (not deduplicated against the previous value), until cancel
+// is called to unregister it and release the channel. The channel holds
+// one value; a value not yet received is replaced by the newer one.
+func (s *grpcHandler) SubscribeServingStatus(service string) (ch <-chan grpc_health_v1.HealthCheckResponse_ServingStatus, cancel func()) {
+	c := make(chan grpc_health_v1.HealthCheckResponse_ServingStatus, 1)
+
+	s.watchersMutex.Lock()
+	if s.watchers[service] == nil {
+		s.watchers[service] = make(map[chan grpc_health_v1.HealthCheckResponse_ServingStatus]struct{})
+	}
+	s.watchers[service][c] = struct{}{}
+	s.watchersMutex.Unlock()
+
+	return c, func() {
+		s.watchersMutex.Lock()
+		delete(s.watchers[service], c)
+		s.watchersMutex.Unlock()
+	}
+}
+
+// notifyWatchers pushes status to every subscriber of service registered
+// via SubscribeServingStatus, dropping a subscriber's stale pending value
+// rather than blocking so that a slow Watch RPC can never stall a check.
+func (s *grpcHandler) notifyWatchers(service string, status grpc_health_v1.HealthCheckResponse_ServingStatus) {
+	s.watchersMutex.Lock()
+	defer s.watchersMutex.Unlock()
+
+	for c := range s.watchers[service] {
+		select {
+		case c <- status:
+		default:
+			select {
+			case <-c:
+			default:
+			}
+			c <- status
+		}
+	}
+}
+
+// resultKey namespaces a check name by service for the flat JSON results
+// returned by ReadyEndpoint/LiveEndpoint, so that checks of the same name
+// registered for different services don't collide. Checks on the default
+// ("") service keep their bare name for backwards compatibility.
+func resultKey(service, name string) string {
+	if service == defaultService {
+		return name
+	}
+	return service + "/" + name
+}
+
+func countChecks(m map[string]map[string]*checkState) (n int) {
+	for _, serviceChecks := range m {
+		n += len(serviceChecks)
+	}
+	return n
+}
+
+// formatCheckResult renders a check's last result for the flat `/ready` and
+// `/live` JSON output: "OK" when it passed, the bare error for a
+// SeverityError failure (preserving the historical format), or the error
+// prefixed with its level for a SeverityWarn/SeverityInfo failure so it can
+// be told apart from a serving-impacting one.
+func formatCheckResult(state *checkState) string {
+	if state.err == nil {
+		return "OK"
+	}
+	if state.severity == checks.SeverityError {
+		return state.err.Error()
+	}
+	return fmt.Sprintf("[%s] %s", state.severity, state.err.Error())
 }
 
 func (s *grpcHandler) readinessOkWithResults(results map[string]string) (ok bool) {
@@ -182,12 +496,13 @@ func (s *grpcHandler) readinessOkWithResults(results map[string]string) (ok bool
 	s.checksMutex.RLock()
 	defer s.checksMutex.RUnlock()
 
-	for name, checkResult := range s.readinessChecks {
-		if checkResult != nil {
-			ok = false
-			results[name] = checkResult.Error()
-		} else {
-			results[name] = "OK"
+	for service, serviceChecks := range s.readinessChecks {
+		for name, state := range serviceChecks {
+			key := resultKey(service, name)
+			if state.err != nil && state.severity == checks.SeverityError {
+				ok = false
+			}
+			results[key] = formatCheckResult(state)
 		}
 	}
 	return
@@ -199,12 +514,13 @@ func (s *grpcHandler) livenessOkWithResults(results map[string]string) (ok bool)
 	s.checksMutex.RLock()
 	defer s.checksMutex.RUnlock()
 
-	for name, checkResult := range s.livenessChecks {
-		if checkResult != nil {
-			ok = false
-			results[name] = checkResult.Error()
-		} else {
-			results[name] = "OK"
+	for service, serviceChecks := range s.livenessChecks {
+		for name, state := range serviceChecks {
+			key := resultKey(service, name)
+			if state.err != nil && state.severity == checks.SeverityError {
+				ok = false
+			}
+			results[key] = formatCheckResult(state)
 		}
 	}
 	return
@@ -216,140 +532,318 @@ func (s *grpcHandler) grpcDepsOkWithResults(results map[string]string) (ok bool)
 	s.grpcDepsMutex.RLock()
 	defer s.grpcDepsMutex.RUnlock()
 
-	for name, checkResult := range s.grpcDeps {
-		if !checkResult {
-			ok = false
-			results[name] = "grpc service is down"
-		} else {
-			results[name] = "OK"
+	for service, deps := range s.grpcDeps {
+		for name, up := range deps {
+			key := resultKey(service, name)
+			if !up {
+				ok = false
+				results[key] = "grpc service is down"
+			} else {
+				results[key] = "OK"
+			}
 		}
 	}
 	return
 }
 
-// areGrpcDepsOk returns true only when last health checks of all grpc
-// dependencies were succefull.
-func (s *grpcHandler) areGrpcDepsOk() (ok bool) {
+// checkResultV2 is a single check's entry in the `?format=v2` JSON
+// envelope, carrying enough history to build dashboards without having to
+// scrape `/ready?full=1` on a tight interval.
+type checkResultV2 struct {
+	Status             string `json:"status"`
+	Level              string `json:"level,omitempty"`
+	Error              string `json:"error,omitempty"`
+	LastCheck          string `json:"last_check,omitempty"`
+	LastSuccess        string `json:"last_success,omitempty"`
+	ContiguousFailures int    `json:"contiguous_failures"`
+	DurationMs         int64  `json:"duration_ms"`
+}
+
+// healthResultV2 is the root of the `?format=v2` JSON envelope.
+type healthResultV2 struct {
+	Status string                   `json:"status"`
+	Checks map[string]checkResultV2 `json:"checks"`
+}
+
+func checkResultV2FromState(state *checkState) checkResultV2 {
+	result := checkResultV2{
+		Status:             "OK",
+		ContiguousFailures: state.contiguousFailures,
+		DurationMs:         state.lastDuration.Milliseconds(),
+	}
+	if !state.lastCheck.IsZero() {
+		result.LastCheck = state.lastCheck.Format(time.RFC3339)
+	}
+	if !state.lastSuccess.IsZero() {
+		result.LastSuccess = state.lastSuccess.Format(time.RFC3339)
+	}
+	if state.err != nil {
+		result.Error = state.err.Error()
+		if state.severity == checks.SeverityError {
+			result.Status = "FAIL"
+		} else {
+			result.Status = "WARN"
+			result.Level = string(state.severity)
+		}
+	}
+	return result
+}
+
+// checksResultsV2 merges readiness checks, liveness checks and grpc
+// dependencies into the `checks` map of the `?format=v2` envelope, and
+// reports whether they all passed at SeverityError (the same notion of
+// "ok" used by the flat/v1 payload and by the HTTP status code).
+func (s *grpcHandler) checksResultsV2(results map[string]checkResultV2) (ok bool) {
 	ok = true
 
+	s.checksMutex.RLock()
+	for service, serviceChecks := range s.readinessChecks {
+		for name, state := range serviceChecks {
+			if state.err != nil && state.severity == checks.SeverityError {
+				ok = false
+			}
+			results[resultKey(service, name)] = checkResultV2FromState(state)
+		}
+	}
+	for service, serviceChecks := range s.livenessChecks {
+		for name, state := range serviceChecks {
+			if state.err != nil && state.severity == checks.SeverityError {
+				ok = false
+			}
+			results[resultKey(service, name)] = checkResultV2FromState(state)
+		}
+	}
+	s.checksMutex.RUnlock()
+
 	s.grpcDepsMutex.RLock()
 	defer s.grpcDepsMutex.RUnlock()
+	for service, deps := range s.grpcDeps {
+		for name, up := range deps {
+			result := checkResultV2{Status: "OK"}
+			if !up {
+				ok = false
+				result.Status = "FAIL"
+				result.Error = "grpc service is down"
+			}
+			results[resultKey(service, name)] = result
+		}
+	}
+	return
+}
+
+// livenessResultsV2 is the liveness-only counterpart of checksResultsV2,
+// used by LiveEndpoint.
+func (s *grpcHandler) livenessResultsV2(results map[string]checkResultV2) (ok bool) {
+	ok = true
+
+	s.checksMutex.RLock()
+	defer s.checksMutex.RUnlock()
 
-	for _, checkResult := range s.grpcDeps {
-		if !checkResult {
-			ok = false
-			break
+	for service, serviceChecks := range s.livenessChecks {
+		for name, state := range serviceChecks {
+			if state.err != nil && state.severity == checks.SeverityError {
+				ok = false
+			}
+			results[resultKey(service, name)] = checkResultV2FromState(state)
 		}
 	}
 	return
 }
 
+// wantsV2Format reports whether the request asked for the structured
+// `?format=v2` JSON envelope, either via that query parameter or via the
+// "application/health+json" media type in its Accept header.
+func wantsV2Format(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "v2" {
+		return true
+	}
+	for _, accept := range r.Header["Accept"] {
+		if strings.Contains(accept, "application/health+json") {
+			return true
+		}
+	}
+	return false
+}
+
+// AddReadinessCheck adds a check that indicates that this instance of the
+// application is currently unable to serve requests, scoped to the overall
+// ("") service and to SeverityError. See AddReadinessCheckForService and
+// AddReadinessCheckWithSeverity.
 func (s *grpcHandler) AddReadinessCheck(name string, check checks.Check, interval time.Duration) error {
+	return s.AddReadinessCheckForService(defaultService, name, check, interval)
+}
+
+// AddReadinessCheckForService is like AddReadinessCheck, but scopes the
+// check to a named gRPC service: flipping it only affects that service's
+// serving status (and, transitively, the overall "" status).
+func (s *grpcHandler) AddReadinessCheckForService(service, name string, check checks.Check, interval time.Duration) error {
+	return s.AddReadinessCheckForServiceWithSeverity(service, name, check, interval, checks.SeverityError)
+}
+
+// AddReadinessCheckWithSeverity is like AddReadinessCheck, but lets the
+// caller pick a severity other than the default SeverityError. Only
+// SeverityError failures flip the serving status and make `/ready` return
+// 503; SeverityWarn and SeverityInfo failures are only reported in the
+// `?full=1` JSON output.
+func (s *grpcHandler) AddReadinessCheckWithSeverity(name string, check checks.Check, interval time.Duration, severity checks.Severity) error {
+	return s.AddReadinessCheckForServiceWithSeverity(defaultService, name, check, interval, severity)
+}
+
+// AddReadinessCheckForServiceWithSeverity combines AddReadinessCheckForService
+// and AddReadinessCheckWithSeverity.
+func (s *grpcHandler) AddReadinessCheckForServiceWithSeverity(service, name string, check checks.Check, interval time.Duration, severity checks.Severity) error {
 	s.checksMutex.Lock()
-	defer s.checksMutex.Unlock()
 
-	if _, ok := s.readinessChecks[name]; ok {
-		return fmt.Errorf("readiness check '%s' already exists", name)
+	if s.readinessChecks[service] == nil {
+		s.readinessChecks[service] = make(map[string]*checkState)
+	}
+	if _, ok := s.readinessChecks[service][name]; ok {
+		s.checksMutex.Unlock()
+		return fmt.Errorf("readiness check '%s' already exists for service '%s'", name, service)
 	}
 
 	// we start in failed state
-	s.readinessChecks[name] = errors.New("placeholder")
+	s.readinessChecks[service][name] = &checkState{err: errors.New("placeholder"), severity: severity}
+	s.checksMutex.Unlock()
 
 	checks.AsyncWithContext(s.globalContext,
 		func() error {
+			start := time.Now()
 			err := check()
+			duration := time.Since(start)
+			if s.metrics != nil {
+				s.metrics.Observe(name, "readiness", err, duration)
+			}
 
 			s.checksMutex.Lock()
 
+			state := s.readinessChecks[service][name]
+			wasOk := state.err == nil
+			firstRun := state.lastCheck.IsZero()
+
+			state.lastCheck = start
+			state.lastDuration = duration
+			if err == nil {
+				state.lastSuccess = start
+				state.contiguousFailures = 0
+			} else {
+				state.contiguousFailures++
+			}
+			state.err = err
+
+			s.checksMutex.Unlock()
+
 			switch {
 			// check was fine, now it is KO
-			case s.readinessChecks[name] == nil && err != nil:
+			case wasOk && err != nil:
 				if s.log != nil {
-					s.log.Debug("Readiness check: OK -> FAILED", zap.String("name", name))
+					s.log.Debug("Readiness check: OK -> FAILED", zap.String("service", service), zap.String("name", name))
 				}
-
-				s.readinessChecks[name] = err
-				s.checksMutex.Unlock()
-
-				// we can set serving status immediately
-				s.grpcHealthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+				s.updateServingStatus(service)
 
 			// check was KO, now it's fine
-			case s.readinessChecks[name] != nil && err == nil:
+			case !wasOk && err == nil:
 				if s.log != nil {
-					s.log.Debug("Readiness check: FAILED -> OK", zap.String("name", name))
-				}
-
-				s.readinessChecks[name] = err
-				s.checksMutex.Unlock()
-
-				// we must check readiness/liveness checks and grpc deps before setting serving status
-				ok := true
-				ok = ok && s.areChecksOk()
-				ok = ok && s.areGrpcDepsOk()
-
-				if ok {
-					s.grpcHealthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+					s.log.Debug("Readiness check: FAILED -> OK", zap.String("service", service), zap.String("name", name))
 				}
+				s.updateServingStatus(service)
 
-			default:
-				s.checksMutex.Unlock()
+			// first result of a check that is still failing: publish the
+			// status now, since a SeverityWarn/SeverityInfo failure leaves
+			// the service serving
+			case firstRun:
+				s.updateServingStatus(service)
 			}
 			return nil
 		}, interval)
 	return nil
 }
 
+// AddLivenessCheck adds a check that indicates that this instance of the
+// application should be destroyed or restarted, scoped to the overall ("")
+// service and to SeverityError. See AddLivenessCheckForService and
+// AddLivenessCheckWithSeverity.
 func (s *grpcHandler) AddLivenessCheck(name string, check checks.Check, interval time.Duration) error {
+	return s.AddLivenessCheckForService(defaultService, name, check, interval)
+}
+
+// AddLivenessCheckForService is like AddLivenessCheck, but scopes the check
+// to a named gRPC service: flipping it only affects that service's serving
+// status (and, transitively, the overall "" status).
+func (s *grpcHandler) AddLivenessCheckForService(service, name string, check checks.Check, interval time.Duration) error {
+	return s.AddLivenessCheckForServiceWithSeverity(service, name, check, interval, checks.SeverityError)
+}
+
+// AddLivenessCheckWithSeverity is like AddLivenessCheck, but lets the
+// caller pick a severity other than the default SeverityError.
+func (s *grpcHandler) AddLivenessCheckWithSeverity(name string, check checks.Check, interval time.Duration, severity checks.Severity) error {
+	return s.AddLivenessCheckForServiceWithSeverity(defaultService, name, check, interval, severity)
+}
+
+// AddLivenessCheckForServiceWithSeverity combines AddLivenessCheckForService
+// and AddLivenessCheckWithSeverity.
+func (s *grpcHandler) AddLivenessCheckForServiceWithSeverity(service, name string, check checks.Check, interval time.Duration, severity checks.Severity) error {
 	s.checksMutex.Lock()
-	defer s.checksMutex.Unlock()
 
-	if _, ok := s.livenessChecks[name]; ok {
-		return fmt.Errorf("liveness check '%s' already exists", name)
+	if s.livenessChecks[service] == nil {
+		s.livenessChecks[service] = make(map[string]*checkState)
+	}
+	if _, ok := s.livenessChecks[service][name]; ok {
+		s.checksMutex.Unlock()
+		return fmt.Errorf("liveness check '%s' already exists for service '%s'", name, service)
 	}
 
 	// we start in failed state
-	s.livenessChecks[name] = errors.New("placeholder")
+	s.livenessChecks[service][name] = &checkState{err: errors.New("placeholder"), severity: severity}
+	s.checksMutex.Unlock()
 
 	checks.AsyncWithContext(s.globalContext,
 		func() error {
+			start := time.Now()
 			err := check()
+			duration := time.Since(start)
+			if s.metrics != nil {
+				s.metrics.Observe(name, "liveness", err, duration)
+			}
 
 			s.checksMutex.Lock()
 
+			state := s.livenessChecks[service][name]
+			wasOk := state.err == nil
+			firstRun := state.lastCheck.IsZero()
+
+			state.lastCheck = start
+			state.lastDuration = duration
+			if err == nil {
+				state.lastSuccess = start
+				state.contiguousFailures = 0
+			} else {
+				state.contiguousFailures++
+			}
+			state.err = err
+
+			s.checksMutex.Unlock()
+
 			switch {
 			// check was fine, now it is KO
-			case s.livenessChecks[name] == nil && err != nil:
+			case wasOk && err != nil:
 				if s.log != nil {
-					s.log.Debug("Liveness check: OK -> FAILED", zap.String("name", name))
+					s.log.Debug("Liveness check: OK -> FAILED", zap.String("service", service), zap.String("name", name))
 				}
-
-				s.livenessChecks[name] = err
-				s.checksMutex.Unlock()
-
-				// we can set serving status immediately
-				s.grpcHealthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+				s.updateServingStatus(service)
 
 			// check was KO, now it's fine
-			case s.livenessChecks[name] != nil && err == nil:
+			case !wasOk && err == nil:
 				if s.log != nil {
-					s.log.Debug("Liveness check: FAILED -> OK", zap.String("name", name))
-				}
-
-				s.livenessChecks[name] = err
-				s.checksMutex.Unlock()
-
-				// we must check readiness/liveness checks and grpc deps before setting serving status
-				ok := true
-				ok = ok && s.areChecksOk()
-				ok = ok && s.areGrpcDepsOk()
-
-				if ok {
-					s.grpcHealthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+					s.log.Debug("Liveness check: FAILED -> OK", zap.String("service", service), zap.String("name", name))
 				}
+				s.updateServingStatus(service)
 
-			default:
-				s.checksMutex.Unlock()
+			// first result of a check that is still failing: publish the
+			// status now, since a SeverityWarn/SeverityInfo failure leaves
+			// the service serving
+			case firstRun:
+				s.updateServingStatus(service)
 			}
 			return nil
 		}, interval)
@@ -362,15 +856,32 @@ func (s *grpcHandler) ReadyEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	results := make(map[string]string, len(s.livenessChecks)+len(s.readinessChecks))
+	if wantsV2Format(r) {
+		results := make(map[string]checkResultV2)
+
+		// both must always run: if a liveness check is down, readiness
+		// checks and grpc deps must still be populated in the envelope.
+		okL := s.livenessResultsV2(results)
+		okC := s.checksResultsV2(results)
+
+		s.writeV2Response(w, r, okL && okC, results)
+		return
+	}
+
+	s.checksMutex.RLock()
+	resultsCap := countChecks(s.livenessChecks) + countChecks(s.readinessChecks)
+	s.checksMutex.RUnlock()
+
+	results := make(map[string]string, resultsCap)
 	status := http.StatusOK
 
-	ok := true
-	ok = ok && s.livenessOkWithResults(results)
-	ok = ok && s.readinessOkWithResults(results)
-	ok = ok && s.grpcDepsOkWithResults(results)
+	// all three must always run: if a liveness check is down, readiness
+	// checks and grpc deps must still be populated in the body.
+	okL := s.livenessOkWithResults(results)
+	okR := s.readinessOkWithResults(results)
+	okG := s.grpcDepsOkWithResults(results)
 
-	if !ok {
+	if !(okL && okR && okG) {
 		status = http.StatusServiceUnavailable
 	}
 
@@ -392,13 +903,43 @@ func (s *grpcHandler) ReadyEndpoint(w http.ResponseWriter, r *http.Request) {
 	_ = encoder.Encode(results)
 }
 
+// writeV2Response writes the structured `?format=v2` JSON envelope. Unlike
+// the v1 payload, the envelope (with its overall status) is always written,
+// even without `?full=1`, since it's the whole point of asking for it.
+func (s *grpcHandler) writeV2Response(w http.ResponseWriter, r *http.Request, ok bool, results map[string]checkResultV2) {
+	status := http.StatusOK
+	overall := "OK"
+	if !ok {
+		status = http.StatusServiceUnavailable
+		overall = "FAIL"
+	}
+
+	w.Header().Set("Content-Type", "application/health+json; charset=utf-8")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	_ = encoder.Encode(healthResultV2{Status: overall, Checks: results})
+}
+
 func (s *grpcHandler) LiveEndpoint(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	results := make(map[string]string, len(s.livenessChecks)+len(s.readinessChecks))
+	if wantsV2Format(r) {
+		results := make(map[string]checkResultV2)
+		ok := s.livenessResultsV2(results)
+		s.writeV2Response(w, r, ok, results)
+		return
+	}
+
+	s.checksMutex.RLock()
+	resultsCap := countChecks(s.livenessChecks) + countChecks(s.readinessChecks)
+	s.checksMutex.RUnlock()
+
+	results := make(map[string]string, resultsCap)
 	status := http.StatusOK
 
 	ok := true
@@ -0,0 +1,386 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/GlobalWebIndex/healthcheck/checks"
+	"github.com/GlobalWebIndex/healthcheck/handlers"
+)
+
+// fakeWatchClient implements grpc_health_v1.Health_WatchClient. Recv fails
+// with io.ErrClosedPipe until failures have been exhausted, then reports
+// SERVING forever.
+type fakeWatchClient struct {
+	grpc.ClientStream
+
+	failures *int32
+}
+
+func (f *fakeWatchClient) Recv() (*grpc_health_v1.HealthCheckResponse, error) {
+	if atomic.AddInt32(f.failures, -1) >= 0 {
+		return nil, io.ErrClosedPipe
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
+
+// fakeHealthClient implements grpc_health_v1.HealthClient. The streams
+// returned by Watch share one failure budget: their Recv calls fail until
+// `failures` errors have been returned in total, simulating a dependency
+// whose first few streams break before the connection stabilizes.
+type fakeHealthClient struct {
+	failures   int32
+	watchCalls int32
+}
+
+func (f *fakeHealthClient) Check(ctx context.Context, in *grpc_health_v1.HealthCheckRequest, opts ...grpc.CallOption) (*grpc_health_v1.HealthCheckResponse, error) {
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
+
+func (f *fakeHealthClient) Watch(ctx context.Context, in *grpc_health_v1.HealthCheckRequest, opts ...grpc.CallOption) (grpc_health_v1.Health_WatchClient, error) {
+	atomic.AddInt32(&f.watchCalls, 1)
+	return &fakeWatchClient{failures: &f.failures}, nil
+}
+
+func TestAddGrpcReadinessCheckReconnectsAfterStreamErrors(t *testing.T) {
+	hs := health.NewServer()
+	client := &fakeHealthClient{failures: 3}
+
+	h := handlers.NewGrpcHandler(hs, handlers.WithGrpcWatchBackoff(5*time.Millisecond, 20*time.Millisecond, 0))
+	defer h.Close()
+
+	require.NoError(t, h.AddGrpcReadinessCheck("dep", client))
+
+	deadline := time.After(2 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/ready", nil)
+		h.ReadyEndpoint(rec, req)
+
+		if rec.Code == 200 {
+			break
+		}
+
+		select {
+		case <-deadline:
+			t.Fatal("gRPC dependency never recovered after stream errors")
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	assert.True(t, atomic.LoadInt32(&client.watchCalls) >= 2, "expected at least one reconnect after the stream failed")
+}
+
+func TestWithPrometheusRegistererObservesChecks(t *testing.T) {
+	hs := health.NewServer()
+	reg := prometheus.NewRegistry()
+	client := &fakeHealthClient{failures: 0}
+
+	h := handlers.NewGrpcHandler(hs, handlers.WithPrometheusRegisterer(reg))
+	defer h.Close()
+
+	require.NoError(t, h.AddReadinessCheck("pg-ping", func() error { return nil }, time.Millisecond))
+	// a long interval keeps disk-space to its single initial (failing) run
+	require.NoError(t, h.AddLivenessCheck("disk-space", func() error { return errors.New("disk full") }, time.Hour))
+	require.NoError(t, h.AddGrpcReadinessCheck("dep", client))
+
+	deadline := time.After(2 * time.Second)
+	for {
+		err := testutil.GatherAndCompare(reg, strings.NewReader(`
+			# HELP healthcheck_status Whether a health check is currently passing (1) or failing (0).
+			# TYPE healthcheck_status gauge
+			healthcheck_status{kind="grpc_dep",name="dep"} 1
+			healthcheck_status{kind="liveness",name="disk-space"} 0
+			healthcheck_status{kind="readiness",name="pg-ping"} 1
+		`), "healthcheck_status")
+		if err == nil {
+			break
+		}
+
+		select {
+		case <-deadline:
+			t.Fatalf("metrics never reflected check results: %v", err)
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP healthcheck_failures_total Total number of times a health check has failed.
+		# TYPE healthcheck_failures_total counter
+		healthcheck_failures_total{kind="liveness",name="disk-space"} 1
+	`), "healthcheck_failures_total"))
+}
+
+func TestAddReadinessCheckForServiceScopesServingStatus(t *testing.T) {
+	hs := health.NewServer()
+	h := handlers.NewGrpcHandler(hs)
+	defer h.Close()
+
+	failing := make(chan struct{})
+	require.NoError(t, h.AddReadinessCheckForService("svc-a", "check-a", func() error {
+		select {
+		case <-failing:
+			return errors.New("svc-a is down")
+		default:
+			return nil
+		}
+	}, time.Millisecond))
+	require.NoError(t, h.AddReadinessCheckForService("svc-b", "check-b", func() error {
+		return nil
+	}, time.Millisecond))
+
+	waitForServingStatus(t, hs, "svc-a", grpc_health_v1.HealthCheckResponse_SERVING)
+	waitForServingStatus(t, hs, "svc-b", grpc_health_v1.HealthCheckResponse_SERVING)
+	waitForServingStatus(t, hs, "", grpc_health_v1.HealthCheckResponse_SERVING)
+
+	close(failing)
+
+	waitForServingStatus(t, hs, "svc-a", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	waitForServingStatus(t, hs, "", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, servingStatus(t, hs, "svc-b"),
+		"an unrelated service must not be affected by svc-a going down")
+}
+
+// servingStatus returns hs's current serving status for service. Before
+// AddReadinessCheckForService's first check tick has run, hs hasn't had
+// SetServingStatus called for that service yet and Check returns
+// codes.NotFound; servingStatus reports that as SERVICE_UNKNOWN so
+// waitForServingStatus's polling loop just keeps waiting instead of
+// failing the test on an expected transient state.
+func servingStatus(t *testing.T, hs *health.Server, service string) grpc_health_v1.HealthCheckResponse_ServingStatus {
+	t.Helper()
+	resp, err := hs.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: service})
+	if status.Code(err) == codes.NotFound {
+		return grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN
+	}
+	require.NoError(t, err)
+	return resp.Status
+}
+
+func waitForServingStatus(t *testing.T, hs *health.Server, service string, want grpc_health_v1.HealthCheckResponse_ServingStatus) {
+	t.Helper()
+
+	deadline := time.After(2 * time.Second)
+	for {
+		if servingStatus(t, hs, service) == want {
+			return
+		}
+
+		select {
+		case <-deadline:
+			t.Fatalf("service %q never reached serving status %s", service, want)
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func TestAddReadinessCheckWithSeverityMixedScenarios(t *testing.T) {
+	hs := health.NewServer()
+	h := handlers.NewGrpcHandler(hs)
+	defer h.Close()
+
+	require.NoError(t, h.AddReadinessCheckWithSeverity("warming-up", func() error {
+		return errors.New("cache still warming up")
+	}, time.Millisecond, checks.SeverityInfo))
+
+	// a SeverityInfo failure is reported but must not take the instance
+	// out of rotation
+	waitForReadyResult(t, h, "warming-up", "[info] cache still warming up")
+	assertServingStatusStays(t, hs, defaultService, grpc_health_v1.HealthCheckResponse_SERVING)
+
+	require.NoError(t, h.AddReadinessCheckWithSeverity("degraded-upstream", func() error {
+		return errors.New("one of three upstreams is down")
+	}, time.Millisecond, checks.SeverityWarn))
+
+	waitForReadyResult(t, h, "degraded-upstream", "[warn] one of three upstreams is down")
+	assertServingStatusStays(t, hs, defaultService, grpc_health_v1.HealthCheckResponse_SERVING)
+
+	require.NoError(t, h.AddReadinessCheckWithSeverity("primary-db", func() error {
+		return errors.New("connection refused")
+	}, time.Millisecond, checks.SeverityError))
+
+	waitForReadyResult(t, h, "primary-db", "connection refused")
+	waitForServingStatus(t, hs, defaultService, grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+}
+
+const defaultService = ""
+
+func waitForReadyResult(t *testing.T, h handlers.GrpcHandler, name, want string) {
+	t.Helper()
+
+	deadline := time.After(2 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/ready?full=1", nil)
+		h.ReadyEndpoint(rec, req)
+
+		var results map[string]string
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &results))
+
+		if results[name] == want {
+			return
+		}
+
+		select {
+		case <-deadline:
+			t.Fatalf("check %q never reported %q, last results: %v", name, want, results)
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func assertServingStatusStays(t *testing.T, hs *health.Server, service string, want grpc_health_v1.HealthCheckResponse_ServingStatus) {
+	t.Helper()
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, want, servingStatus(t, hs, service))
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReadyEndpointFormatV2(t *testing.T) {
+	hs := health.NewServer()
+	h := handlers.NewGrpcHandler(hs)
+	defer h.Close()
+
+	require.NoError(t, h.AddReadinessCheckWithSeverity("broken", func() error {
+		return errors.New("boom")
+	}, time.Millisecond, checks.SeverityError))
+
+	var body map[string]interface{}
+	deadline := time.After(2 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/ready?format=v2", nil)
+		h.ReadyEndpoint(rec, req)
+
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+
+		if body["status"] == "FAIL" {
+			assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+			break
+		}
+
+		select {
+		case <-deadline:
+			t.Fatalf("check never reported FAIL in v2 format, last body: %v", body)
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	checksField, ok := body["checks"].(map[string]interface{})
+	require.True(t, ok, "expected a checks object in the v2 envelope")
+
+	entry, ok := checksField["broken"].(map[string]interface{})
+	require.True(t, ok, "expected an entry for the 'broken' check")
+
+	assert.Equal(t, "FAIL", entry["status"])
+	assert.Equal(t, "boom", entry["error"])
+	assert.NotEmpty(t, entry["last_check"])
+	assert.Contains(t, entry, "contiguous_failures")
+}
+
+func TestReadyEndpointFormatV2IncludesReadinessEvenWhenLivenessFails(t *testing.T) {
+	hs := health.NewServer()
+	h := handlers.NewGrpcHandler(hs)
+	defer h.Close()
+
+	require.NoError(t, h.AddLivenessCheck("broken-liveness", func() error {
+		return errors.New("out of memory")
+	}, time.Millisecond))
+	require.NoError(t, h.AddReadinessCheck("ok-readiness", func() error {
+		return nil
+	}, time.Millisecond))
+
+	var body map[string]interface{}
+	deadline := time.After(2 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/ready?format=v2", nil)
+		h.ReadyEndpoint(rec, req)
+
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+
+		checksField, ok := body["checks"].(map[string]interface{})
+		if ok {
+			if _, ok := checksField["ok-readiness"]; ok {
+				break
+			}
+		}
+
+		select {
+		case <-deadline:
+			t.Fatalf("readiness check never appeared in v2 envelope, last body: %v", body)
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	assert.Equal(t, "FAIL", body["status"], "overall status must reflect the failing liveness check")
+
+	checksField, ok := body["checks"].(map[string]interface{})
+	require.True(t, ok, "expected a checks object in the v2 envelope")
+
+	_, ok = checksField["broken-liveness"]
+	assert.True(t, ok, "expected the failing liveness check to still be reported")
+
+	entry, ok := checksField["ok-readiness"].(map[string]interface{})
+	require.True(t, ok, "readiness checks must still be reported when a liveness check fails")
+	assert.Equal(t, "OK", entry["status"])
+}
+
+func TestReadyEndpointIncludesReadinessEvenWhenLivenessFails(t *testing.T) {
+	hs := health.NewServer()
+	h := handlers.NewGrpcHandler(hs)
+	defer h.Close()
+
+	require.NoError(t, h.AddLivenessCheck("broken-liveness", func() error {
+		return errors.New("out of memory")
+	}, time.Millisecond))
+	require.NoError(t, h.AddReadinessCheck("ok-readiness", func() error {
+		return nil
+	}, time.Millisecond))
+
+	var results map[string]string
+	deadline := time.After(2 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/ready?full=1", nil)
+		h.ReadyEndpoint(rec, req)
+
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &results))
+
+		if _, ok := results["ok-readiness"]; ok {
+			assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+			break
+		}
+
+		select {
+		case <-deadline:
+			t.Fatalf("readiness check never appeared in the v1 body, last results: %v", results)
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	assert.Contains(t, results, "broken-liveness", "expected the failing liveness check to still be reported")
+	assert.Equal(t, "OK", results["ok-readiness"], "readiness checks must still be reported when a liveness check fails")
+}
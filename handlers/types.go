@@ -74,6 +74,59 @@ type GrpcHandler interface {
 	// listens for health status changes of a gRPC dependency.
 	AddGrpcReadinessCheck(name string, grpcClient grpc_health_v1.HealthClient) error
 
+	// AddReadinessCheckForService is like AddReadinessCheck, but scopes the
+	// check to a named gRPC service: flipping it only affects that
+	// service's serving status, reported separately from the overall ("")
+	// one, so a consumer watching just that service isn't taken down by an
+	// unrelated dependency.
+	AddReadinessCheckForService(service, name string, check checks.Check, interval time.Duration) error
+
+	// AddLivenessCheckForService is like AddLivenessCheck, but scopes the
+	// check to a named gRPC service.
+	AddLivenessCheckForService(service, name string, check checks.Check, interval time.Duration) error
+
+	// AddGrpcReadinessCheckForService is like AddGrpcReadinessCheck, but
+	// scopes the dependency to a named gRPC service.
+	AddGrpcReadinessCheckForService(service, name string, grpcClient grpc_health_v1.HealthClient) error
+
+	// AddReadinessCheckWithSeverity is like AddReadinessCheck, but lets the
+	// caller pick a severity other than the default checks.SeverityError.
+	// Only SeverityError failures flip the serving status and make
+	// `/ready` return 503; SeverityWarn and SeverityInfo failures are only
+	// reported in the `?full=1` JSON output.
+	AddReadinessCheckWithSeverity(name string, check checks.Check, interval time.Duration, severity checks.Severity) error
+
+	// AddLivenessCheckWithSeverity is like AddLivenessCheck, but lets the
+	// caller pick a severity other than the default checks.SeverityError.
+	AddLivenessCheckWithSeverity(name string, check checks.Check, interval time.Duration, severity checks.Severity) error
+
+	// AddReadinessCheckForServiceWithSeverity combines
+	// AddReadinessCheckForService and AddReadinessCheckWithSeverity.
+	AddReadinessCheckForServiceWithSeverity(service, name string, check checks.Check, interval time.Duration, severity checks.Severity) error
+
+	// AddLivenessCheckForServiceWithSeverity combines
+	// AddLivenessCheckForService and AddLivenessCheckWithSeverity.
+	AddLivenessCheckForServiceWithSeverity(service, name string, check checks.Check, interval time.Duration, severity checks.Severity) error
+
+	// KnownService reports whether service has at least one check or grpc
+	// dependency registered, or is the overall "" service. It's used by
+	// the grpchealth subpackage to return codes.NotFound for a service
+	// nobody registered, matching the gRPC Health Checking Protocol.
+	KnownService(service string) bool
+
+	// ServingStatus reports service's current gRPC serving status,
+	// computed directly from its registered checks. It's used by the
+	// grpchealth subpackage to answer Check RPCs without requiring a
+	// separately-owned health.Server.
+	ServingStatus(service string) grpc_health_v1.HealthCheckResponse_ServingStatus
+
+	// SubscribeServingStatus registers a subscriber for changes to
+	// service's serving status: a value is sent on the returned channel
+	// every time the status is recomputed, until cancel is called to
+	// unregister it; a value not yet received is replaced by the newer
+	// one. It's used by the grpchealth subpackage to answer Watch RPCs.
+	SubscribeServingStatus(service string) (ch <-chan grpc_health_v1.HealthCheckResponse_ServingStatus, cancel func())
+
 	// Close performs cleanup on all background checks and resources
 	Close()
 }
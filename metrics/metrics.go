@@ -0,0 +1,65 @@
+// Package metrics exports Prometheus metrics for the checks registered on a
+// GrpcHandler, so operators can alert on flapping dependencies without
+// having to scrape /ready?full=1.
+package metrics
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics exports the status, duration and failure count of every
+// registered health check. It is created with New and wired into a
+// GrpcHandler via WithPrometheusRegisterer.
+type Metrics struct {
+	status   *prometheus.GaugeVec
+	duration *prometheus.HistogramVec
+	failures *prometheus.CounterVec
+}
+
+// New creates a Metrics collector and registers it with reg. It panics if
+// the collectors can't be registered (e.g. duplicate registration), the
+// same way prometheus.MustRegister does, since this is only ever called
+// once at startup when wiring up a GrpcHandler.
+func New(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		status: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "healthcheck_status",
+			Help: "Whether a health check is currently passing (1) or failing (0).",
+		}, []string{"name", "kind"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "healthcheck_duration_seconds",
+			Help: "Time it took to run a health check, in seconds.",
+		}, []string{"name", "kind"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "healthcheck_failures_total",
+			Help: "Total number of times a health check has failed.",
+		}, []string{"name", "kind"}),
+	}
+
+	for _, c := range []prometheus.Collector{m.status, m.duration, m.failures} {
+		if err := reg.Register(c); err != nil {
+			panic(fmt.Sprintf("metrics: failed to register collector: %v", err))
+		}
+	}
+
+	return m
+}
+
+// Observe records the outcome of a single check invocation for the check
+// called name of the given kind (e.g. "liveness", "readiness", "grpc_dep"):
+// the pass/fail status gauge, how long the check took, and whether it
+// failed.
+func (m *Metrics) Observe(name, kind string, err error, duration time.Duration) {
+	m.duration.WithLabelValues(name, kind).Observe(duration.Seconds())
+
+	if err != nil {
+		m.status.WithLabelValues(name, kind).Set(0)
+		m.failures.WithLabelValues(name, kind).Inc()
+		return
+	}
+
+	m.status.WithLabelValues(name, kind).Set(1)
+}
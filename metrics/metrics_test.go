@@ -0,0 +1,48 @@
+package metrics_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/GlobalWebIndex/healthcheck/metrics"
+)
+
+func TestObserveSuccess(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := metrics.New(reg)
+
+	m.Observe("pg-ping", "readiness", nil, 3*time.Millisecond)
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP healthcheck_status Whether a health check is currently passing (1) or failing (0).
+		# TYPE healthcheck_status gauge
+		healthcheck_status{kind="readiness",name="pg-ping"} 1
+	`), "healthcheck_status"))
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(``), "healthcheck_failures_total"))
+}
+
+func TestObserveFailure(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := metrics.New(reg)
+
+	m.Observe("dep", "grpc_dep", errors.New("connection refused"), 0)
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP healthcheck_status Whether a health check is currently passing (1) or failing (0).
+		# TYPE healthcheck_status gauge
+		healthcheck_status{kind="grpc_dep",name="dep"} 0
+	`), "healthcheck_status"))
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP healthcheck_failures_total Total number of times a health check has failed.
+		# TYPE healthcheck_failures_total counter
+		healthcheck_failures_total{kind="grpc_dep",name="dep"} 1
+	`), "healthcheck_failures_total"))
+}